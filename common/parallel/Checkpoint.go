@@ -0,0 +1,215 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// defaultCheckpointInterval is how often ResumeCrawl flushes its checkpoint when
+// CrawlOptions.CheckpointInterval isn't set.
+const defaultCheckpointInterval = 30 * time.Second
+
+// ErrCheckpointLocked is returned by ResumeCrawl when another process already holds the
+// advisory lock on the checkpoint file, so that two azcopy processes never drive the same
+// checkpoint at once.
+type ErrCheckpointLocked struct {
+	Path string
+}
+
+func (e ErrCheckpointLocked) Error() string {
+	return fmt.Sprintf("checkpoint %s is locked by another process", e.Path)
+}
+
+// ResumeMarker is emitted as the first CrawlResult from ResumeCrawl whenever an existing
+// checkpoint was found (as opposed to starting a brand new one), so callers can report how
+// much of the crawl a previous run had already completed.
+type ResumeMarker struct {
+	// CompletedOffset is the watermark of directories the crawl had already finished
+	// processing as of the checkpoint being resumed from.
+	CompletedOffset int64
+}
+
+// CrawlCheckpoint periodically persists a resumable crawl's frontier, in-progress
+// directories, and completed-directory watermark to disk, guarded by a cross-process
+// advisory file lock so concurrent azcopy processes can't corrupt the same checkpoint.
+type CrawlCheckpoint struct {
+	path      string
+	marshaler *DirectoryMarshaler
+	interval  time.Duration
+	lock      *fileLock
+
+	completedAtLoad int64
+	resumed         bool
+}
+
+// newCheckpoint acquires the cross-process lock on path and, if a checkpoint already exists
+// there, loads its saved frontier, in-progress directories, and completed watermark.
+func newCheckpoint(path string, marshaler *DirectoryMarshaler, interval time.Duration) (cp *CrawlCheckpoint, frontier, inProgress []Directory, err error) {
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	lock, err := acquireFileLock(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cp = &CrawlCheckpoint{path: path, marshaler: marshaler, interval: interval, lock: lock}
+
+	frontier, inProgress, completed, err := loadCheckpointFile(path, marshaler)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cp, nil, nil, nil // nothing to resume from: this is a brand new checkpoint
+		}
+		_ = lock.release()
+		return nil, nil, nil, err
+	}
+	cp.completedAtLoad = completed
+	cp.resumed = true
+	return cp, frontier, inProgress, nil
+}
+
+// save atomically overwrites the checkpoint file with frontier, inProgress, and completed,
+// by writing to a temp file and renaming it over path - so a crash mid-write never leaves a
+// torn checkpoint behind.
+func (cp *CrawlCheckpoint) save(frontier, inProgress []Directory, completed int64) error {
+	tmpPath := cp.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("creating checkpoint temp file: %w", err)
+	}
+
+	if err := writeCheckpoint(f, cp.marshaler, frontier, inProgress, completed); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing checkpoint temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing checkpoint temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, cp.path); err != nil {
+		return fmt.Errorf("committing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// close releases the cross-process lock on the checkpoint file. The checkpoint file itself
+// is left in place, since a crawl that's merely interrupted (rather than finished) still
+// needs it for its next ResumeCrawl.
+func (cp *CrawlCheckpoint) close() error {
+	return cp.lock.release()
+}
+
+func writeCheckpoint(w io.Writer, m *DirectoryMarshaler, frontier, inProgress []Directory, completed int64) error {
+	bw := bufio.NewWriter(w)
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], uint64(completed))
+	if _, err := bw.Write(header[:]); err != nil {
+		return fmt.Errorf("writing checkpoint header: %w", err)
+	}
+	if err := writeDirectories(bw, m, frontier); err != nil {
+		return err
+	}
+	if err := writeDirectories(bw, m, inProgress); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeDirectories(w *bufio.Writer, m *DirectoryMarshaler, dirs []Directory) error {
+	var countBuf [4]byte
+	binary.BigEndian.PutUint32(countBuf[:], uint32(len(dirs)))
+	if _, err := w.Write(countBuf[:]); err != nil {
+		return fmt.Errorf("writing checkpoint directory count: %w", err)
+	}
+	for _, d := range dirs {
+		payload, err := m.Marshal(d)
+		if err != nil {
+			return fmt.Errorf("marshaling checkpointed directory: %w", err)
+		}
+		var lenBuf [4]byte
+		binary.BigEndian.PutUint32(lenBuf[:], uint32(len(payload)))
+		if _, err := w.Write(lenBuf[:]); err != nil {
+			return fmt.Errorf("writing checkpointed directory: %w", err)
+		}
+		if _, err := w.Write(payload); err != nil {
+			return fmt.Errorf("writing checkpointed directory: %w", err)
+		}
+	}
+	return nil
+}
+
+func loadCheckpointFile(path string, m *DirectoryMarshaler) (frontier, inProgress []Directory, completed int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, 0, fmt.Errorf("reading checkpoint header: %w", err)
+	}
+	completed = int64(binary.BigEndian.Uint64(header[:]))
+
+	frontier, err = readDirectories(r, m)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	inProgress, err = readDirectories(r, m)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return frontier, inProgress, completed, nil
+}
+
+func readDirectories(r *bufio.Reader, m *DirectoryMarshaler) ([]Directory, error) {
+	var countBuf [4]byte
+	if _, err := io.ReadFull(r, countBuf[:]); err != nil {
+		return nil, fmt.Errorf("reading checkpoint directory count: %w", err)
+	}
+	count := binary.BigEndian.Uint32(countBuf[:])
+	dirs := make([]Directory, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			return nil, fmt.Errorf("reading checkpointed directory: %w", err)
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, fmt.Errorf("reading checkpointed directory: %w", err)
+		}
+		d, err := m.Unmarshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshaling checkpointed directory: %w", err)
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
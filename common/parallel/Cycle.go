@@ -0,0 +1,120 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// cycleRootParentKey marks the root directory in cycleTracker.parentOf: it's the one
+// identity with no parent of its own.
+const cycleRootParentKey = ""
+
+// ErrCycleDetected is carried as the err of a CrawlResult (not returned from
+// EnumerateOneDirFunc) when CrawlOptions.IdentityFunc reports a directory that's already
+// been visited elsewhere in the tree - e.g. a symlink loop on a local filesystem, or a
+// self-referential virtual directory - so callers can log it instead of the crawl looping
+// forever.
+type ErrCycleDetected struct {
+	// Key is the identity (from IdentityFunc) that was reached more than once.
+	Key string
+	// ParentChain is the list of identities from the root down to the directory that
+	// attempted to re-enqueue Key.
+	ParentChain []string
+}
+
+func (e ErrCycleDetected) Error() string {
+	return fmt.Sprintf("cycle detected: %s already visited (reached again via %s)", e.Key, strings.Join(e.ParentChain, " -> "))
+}
+
+// cycleTracker deduplicates directories by the caller-supplied identity key, so that a
+// cycle in the tree being crawled is reported once, as ErrCycleDetected, instead of
+// crawled forever. The crawler itself stays filesystem-agnostic: it's up to
+// CrawlOptions.IdentityFunc to know what makes two Directory values the same place (e.g.
+// device+inode on Unix).
+type cycleTracker struct {
+	identify func(Directory) (string, bool)
+	parentOf sync.Map // key string -> parent key string
+}
+
+func newCycleTracker(identify func(Directory) (string, bool)) *cycleTracker {
+	return &cycleTracker{identify: identify}
+}
+
+// registerRoot marks root as seen with no parent, so that a cycle looping all the way back
+// to the root is still caught.
+func (t *cycleTracker) registerRoot(root Directory) {
+	if key, ok := t.identify(root); ok {
+		t.parentOf.Store(key, cycleRootParentKey)
+	}
+}
+
+// check reports whether d is newly seen (recording it under parent's key for next time), or
+// a repeat - in which case err describes the cycle. If IdentityFunc can't produce a key for
+// d, d is always treated as new and key is returned empty.
+//
+// A registration made here is tentative until the caller's attempt actually succeeds: if
+// parent is being retried (see RetryPolicy), the caller must roll it back with discard before
+// trying again, or a failed attempt's children would wrongly look like cycles on the retry.
+func (t *cycleTracker) check(d Directory, parent Directory) (key string, isNew bool, err error) {
+	key, ok := t.identify(d)
+	if !ok {
+		return "", true, nil
+	}
+	parentKey, _ := t.identify(parent)
+	if _, loaded := t.parentOf.LoadOrStore(key, parentKey); loaded {
+		return key, false, ErrCycleDetected{Key: key, ParentChain: t.chainTo(parentKey)}
+	}
+	return key, true, nil
+}
+
+// discard rolls back a tentative registration made by check for key under parentKey, so a
+// retried attempt can register it again from scratch. It's a no-op if key's entry has since
+// changed - e.g. a different, still-live path through the tree genuinely reached it first -
+// so a real cross-branch cycle recorded concurrently is never erased.
+func (t *cycleTracker) discard(key, parentKey string) {
+	if key == "" {
+		return
+	}
+	t.parentOf.CompareAndDelete(key, parentKey)
+}
+
+// chainTo walks parentOf back from key to the root, returning identities root-first.
+func (t *cycleTracker) chainTo(key string) []string {
+	var reversed []string
+	visited := map[string]bool{} // guards against ever looping here, even if parentOf were somehow corrupt
+	for key != cycleRootParentKey && !visited[key] {
+		reversed = append(reversed, key)
+		visited[key] = true
+		parent, ok := t.parentOf.Load(key)
+		if !ok {
+			break
+		}
+		key = parent.(string)
+	}
+	chain := make([]string, len(reversed))
+	for i, k := range reversed {
+		chain[len(reversed)-1-i] = k
+	}
+	return chain
+}
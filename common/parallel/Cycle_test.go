@@ -0,0 +1,99 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"errors"
+	"testing"
+)
+
+func stringIdentity(d Directory) (string, bool) { return d.(string), true }
+
+func TestCycleTrackerCheckDetectsARepeatAndReportsItsParentChain(t *testing.T) {
+	tr := newCycleTracker(stringIdentity)
+	tr.registerRoot("/root")
+
+	if _, isNew, err := tr.check("/root/a", "/root"); !isNew || err != nil {
+		t.Fatalf("check(/root/a) = (isNew=%v, err=%v), want (true, nil)", isNew, err)
+	}
+	if _, isNew, err := tr.check("/root/a/b", "/root/a"); !isNew || err != nil {
+		t.Fatalf("check(/root/a/b) = (isNew=%v, err=%v), want (true, nil)", isNew, err)
+	}
+
+	// "/root/a" is reached again, this time via "/root/a/b" - a cycle back up the tree.
+	key, isNew, err := tr.check("/root/a", "/root/a/b")
+	if isNew {
+		t.Fatalf("check on a repeat returned isNew=true")
+	}
+	if key != "/root/a" {
+		t.Errorf("check returned key %q, want \"/root/a\"", key)
+	}
+	var cycleErr ErrCycleDetected
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("check on a repeat returned %v, want ErrCycleDetected", err)
+	}
+	if cycleErr.Key != "/root/a" {
+		t.Errorf("ErrCycleDetected.Key = %q, want \"/root/a\"", cycleErr.Key)
+	}
+	wantChain := []string{"/root", "/root/a", "/root/a/b"}
+	if len(cycleErr.ParentChain) != len(wantChain) {
+		t.Fatalf("ParentChain = %v, want %v", cycleErr.ParentChain, wantChain)
+	}
+	for i, want := range wantChain {
+		if cycleErr.ParentChain[i] != want {
+			t.Fatalf("ParentChain = %v, want %v", cycleErr.ParentChain, wantChain)
+		}
+	}
+}
+
+func TestCycleTrackerTreatsUnidentifiableDirectoriesAsAlwaysNew(t *testing.T) {
+	tr := newCycleTracker(func(Directory) (string, bool) { return "", false })
+	for i := 0; i < 3; i++ {
+		if key, isNew, err := tr.check("/same", "/parent"); key != "" || !isNew || err != nil {
+			t.Fatalf("check() = (%q, %v, %v), want (\"\", true, nil)", key, isNew, err)
+		}
+	}
+}
+
+func TestCycleTrackerDiscardAllowsReRegistrationButNotAcrossADifferentParent(t *testing.T) {
+	tr := newCycleTracker(stringIdentity)
+	tr.registerRoot("/root")
+
+	key, isNew, err := tr.check("/root/a", "/root")
+	if !isNew || err != nil {
+		t.Fatalf("check(/root/a) = (%v, %v), want (true, nil)", isNew, err)
+	}
+
+	// Rolling back the tentative registration lets it be registered again, as if the
+	// attempt that produced it had never run - this is what a retried processOneDirectory
+	// attempt relies on.
+	tr.discard(key, "/root")
+	if _, isNew, err := tr.check("/root/a", "/root"); !isNew || err != nil {
+		t.Fatalf("check(/root/a) after discard = (%v, %v), want (true, nil)", isNew, err)
+	}
+
+	// A discard naming the wrong parent (as if a different, still-live path had genuinely
+	// re-registered the key in the meantime) must not erase that other registration.
+	tr.discard(key, "/someone-else")
+	if _, isNew, err := tr.check("/root/a", "/root"); isNew || err == nil {
+		t.Fatalf("check(/root/a) after a mismatched discard = (%v, %v), want (false, ErrCycleDetected)", isNew, err)
+	}
+}
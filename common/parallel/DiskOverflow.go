@@ -0,0 +1,218 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DirectoryMarshaler converts a Directory to and from the byte representation written into
+// overflow segment files on disk. It must be supplied whenever CrawlOptions.MaxInMemoryDirs
+// is set, since the crawler has no other way to know how to serialize a caller-defined
+// Directory.
+type DirectoryMarshaler struct {
+	Marshal   func(Directory) ([]byte, error)
+	Unmarshal func([]byte) (Directory, error)
+}
+
+// dirsPerSegment caps how many directories are buffered into one spill segment file before
+// it's sealed and a new one is started, so the feeder can reclaim disk space incrementally
+// instead of holding one ever-growing file open for the whole crawl.
+const dirsPerSegment = 2000
+
+// diskOverflow is the on-disk backing store used once the crawler's in-memory frontier
+// (crawler.frontier) is full. Directories are appended to a rotating set of segment
+// files under workDir and consumed oldest-first, by the feeder goroutine in
+// crawler.runOverflowFeeder, once the in-memory frontier drops below its low-water mark.
+type diskOverflow struct {
+	marshaler *DirectoryMarshaler
+	workDir   string
+
+	mu          sync.Mutex
+	sealedPaths []string // sealed segment files awaiting read, oldest first
+
+	writer      *os.File
+	writerBuf   *bufio.Writer
+	writerCount int
+	nextSegment int
+
+	reading     *os.File
+	readingBuf  *bufio.Reader
+	readingPath string
+}
+
+func newDiskOverflow(spillDir string, marshaler *DirectoryMarshaler) (*diskOverflow, error) {
+	if spillDir == "" {
+		spillDir = os.TempDir()
+	}
+	workDir, err := os.MkdirTemp(spillDir, "azcopy-crawl-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("creating crawl spill dir: %w", err)
+	}
+	return &diskOverflow{marshaler: marshaler, workDir: workDir}, nil
+}
+
+// push appends d to the current segment file, sealing it and starting a fresh one once it
+// reaches dirsPerSegment entries. It never blocks on the crawler's in-memory frontier.
+func (o *diskOverflow) push(d Directory) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.writer == nil {
+		if err := o.openNewSegmentLocked(); err != nil {
+			return err
+		}
+	}
+
+	payload, err := o.marshaler.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("marshaling spilled directory: %w", err)
+	}
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(payload)))
+	if _, err := o.writerBuf.Write(lenBuf[:]); err != nil {
+		return fmt.Errorf("writing crawl spill segment: %w", err)
+	}
+	if _, err := o.writerBuf.Write(payload); err != nil {
+		return fmt.Errorf("writing crawl spill segment: %w", err)
+	}
+	o.writerCount++
+
+	if o.writerCount >= dirsPerSegment {
+		return o.sealCurrentSegmentLocked()
+	}
+	return nil
+}
+
+// pop returns the oldest spilled directory, if any is currently available. ok is false only
+// when nothing at all is spilled right now: if the only remaining data is sitting in the
+// still-open write segment, pop seals it first so it doesn't go unseen forever.
+func (o *diskOverflow) pop() (d Directory, ok bool, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for {
+		if o.reading == nil {
+			if len(o.sealedPaths) == 0 {
+				if o.writerCount == 0 {
+					return nil, false, nil
+				}
+				// The active segment is the only place left holding spilled work. Seal it
+				// now instead of waiting for it to reach dirsPerSegment entries, or this
+				// data would stay invisible to pop (and empty) forever whenever the total
+				// spilled count isn't an exact multiple of dirsPerSegment.
+				if err := o.sealCurrentSegmentLocked(); err != nil {
+					return nil, false, err
+				}
+			}
+			path := o.sealedPaths[0]
+			o.sealedPaths = o.sealedPaths[1:]
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, false, fmt.Errorf("opening crawl spill segment: %w", err)
+			}
+			o.reading = f
+			o.readingBuf = bufio.NewReader(f)
+			o.readingPath = path
+		}
+
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(o.readingBuf, lenBuf[:]); err != nil {
+			if err == io.EOF {
+				o.reading.Close()
+				os.Remove(o.readingPath)
+				o.reading = nil
+				o.readingBuf = nil
+				continue // that segment is exhausted; try the next one, if any
+			}
+			return nil, false, fmt.Errorf("reading crawl spill segment: %w", err)
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(o.readingBuf, payload); err != nil {
+			return nil, false, fmt.Errorf("reading crawl spill segment: %w", err)
+		}
+		d, err := o.marshaler.Unmarshal(payload)
+		if err != nil {
+			return nil, false, fmt.Errorf("unmarshaling spilled directory: %w", err)
+		}
+		return d, true, nil
+	}
+}
+
+func (o *diskOverflow) openNewSegmentLocked() error {
+	path := filepath.Join(o.workDir, fmt.Sprintf("segment-%06d", o.nextSegment))
+	o.nextSegment++
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating crawl spill segment: %w", err)
+	}
+	o.writer = f
+	o.writerBuf = bufio.NewWriter(f)
+	o.writerCount = 0
+	return nil
+}
+
+func (o *diskOverflow) sealCurrentSegmentLocked() error {
+	if o.writer == nil {
+		return nil
+	}
+	if err := o.writerBuf.Flush(); err != nil {
+		return fmt.Errorf("flushing crawl spill segment: %w", err)
+	}
+	path := o.writer.Name()
+	if err := o.writer.Close(); err != nil {
+		return fmt.Errorf("closing crawl spill segment: %w", err)
+	}
+	o.sealedPaths = append(o.sealedPaths, path)
+	o.writer = nil
+	o.writerBuf = nil
+	o.writerCount = 0
+	return nil
+}
+
+// close seals any in-flight segment and removes the whole spill work dir, discarding
+// anything still unread. It's called once the crawl's workers have all stopped, whether
+// because the crawl finished or because its context was cancelled.
+func (o *diskOverflow) close() error {
+	o.mu.Lock()
+	if o.writer != nil {
+		o.writer.Close()
+	}
+	if o.reading != nil {
+		o.reading.Close()
+	}
+	o.mu.Unlock()
+	return os.RemoveAll(o.workDir)
+}
+
+// empty reports whether there's nothing left spilled to disk, counting both sealed segments
+// and whatever has been buffered into the still-open write segment.
+func (o *diskOverflow) empty() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.reading == nil && len(o.sealedPaths) == 0 && o.writerCount == 0
+}
@@ -0,0 +1,81 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"fmt"
+	"testing"
+)
+
+func stringMarshaler() *DirectoryMarshaler {
+	return &DirectoryMarshaler{
+		Marshal:   func(d Directory) ([]byte, error) { return []byte(d.(string)), nil },
+		Unmarshal: func(b []byte) (Directory, error) { return string(b), nil },
+	}
+}
+
+// TestDiskOverflowDrainsSpillCountsNotAMultipleOfSegmentSize guards against the active
+// segment becoming permanently invisible whenever the number of spilled directories isn't an
+// exact multiple of dirsPerSegment: pop (and empty) must account for whatever's still
+// buffered in the open write segment, not just sealed ones.
+func TestDiskOverflowDrainsSpillCountsNotAMultipleOfSegmentSize(t *testing.T) {
+	for _, n := range []int{1, 50, dirsPerSegment - 1, dirsPerSegment, dirsPerSegment + 1, 2*dirsPerSegment + 321} {
+		n := n
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			o, err := newDiskOverflow(t.TempDir(), stringMarshaler())
+			if err != nil {
+				t.Fatalf("newDiskOverflow: %v", err)
+			}
+			defer o.close()
+
+			for i := 0; i < n; i++ {
+				if err := o.push(fmt.Sprintf("dir-%d", i)); err != nil {
+					t.Fatalf("push(%d): %v", i, err)
+				}
+			}
+			if o.empty() {
+				t.Fatalf("empty() = true right after pushing %d entries, want false", n)
+			}
+
+			for i := 0; i < n; i++ {
+				d, ok, err := o.pop()
+				if err != nil {
+					t.Fatalf("pop() at entry %d: %v", i, err)
+				}
+				if !ok {
+					t.Fatalf("pop() returned ok=false after only %d of %d entries drained - the active segment was never sealed", i, n)
+				}
+				if want := fmt.Sprintf("dir-%d", i); d != want {
+					t.Fatalf("pop() = %v, want %v", d, want)
+				}
+			}
+
+			// One more pop confirms there's really nothing left (and lets pop notice EOF on
+			// the last segment it read from, which is what empty() relies on below).
+			if d, ok, err := o.pop(); ok || err != nil {
+				t.Fatalf("pop() on a drained overflow = (%v, %v, %v), want (_, false, nil)", d, ok, err)
+			}
+			if !o.empty() {
+				t.Fatalf("empty() = false after draining every pushed entry")
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileLock is a cross-process advisory lock on a checkpoint file, held for the lifetime of
+// a ResumeCrawl so two azcopy processes can't drive (and corrupt) the same checkpoint.
+type fileLock struct {
+	f *os.File
+}
+
+// acquireFileLock takes an exclusive, non-blocking POSIX fcntl lock on path+".lock". It
+// returns ErrCheckpointLocked if another process already holds it.
+func acquireFileLock(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("opening checkpoint lock file: %w", err)
+	}
+
+	flock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: int16(os.SEEK_SET)}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &flock); err != nil {
+		f.Close()
+		return nil, ErrCheckpointLocked{Path: path}
+	}
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) release() error {
+	unlock := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: int16(os.SEEK_SET)}
+	_ = syscall.FcntlFlock(l.f.Fd(), syscall.F_SETLK, &unlock)
+	return l.f.Close()
+}
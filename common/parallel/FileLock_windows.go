@@ -0,0 +1,72 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// fileLock is a cross-process advisory lock on a checkpoint file, held for the lifetime of
+// a ResumeCrawl so two azcopy processes can't drive (and corrupt) the same checkpoint.
+type fileLock struct {
+	f *os.File
+}
+
+var (
+	modkernel32    = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx = modkernel32.NewProc("LockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+)
+
+// acquireFileLock takes an exclusive, non-blocking lock on path+".lock" via LockFileEx. It
+// returns ErrCheckpointLocked if another process already holds it.
+func acquireFileLock(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("opening checkpoint lock file: %w", err)
+	}
+
+	overlapped := new(syscall.Overlapped)
+	ret, _, _ := procLockFileEx.Call(
+		f.Fd(),
+		uintptr(lockfileFailImmediately|lockfileExclusiveLock),
+		0,
+		^uintptr(0),
+		^uintptr(0),
+		uintptr(unsafe.Pointer(overlapped)),
+	)
+	if ret == 0 {
+		f.Close()
+		return nil, ErrCheckpointLocked{Path: path}
+	}
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) release() error {
+	return l.f.Close() // closing the handle releases the lock
+}
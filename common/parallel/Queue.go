@@ -0,0 +1,225 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// CrawlOrder selects how CrawlOptions.Order hands unstarted directories to workers. It has
+// no effect on correctness, only on the order DirectoryEntry results come out in.
+type CrawlOrder int
+
+const (
+	// OrderFIFO processes directories in the order they were discovered, giving today's
+	// default (roughly breadth-first) traversal. It's the zero value.
+	OrderFIFO CrawlOrder = iota
+	// OrderLIFO processes the most recently discovered directory next, giving a
+	// depth-first traversal.
+	OrderLIFO
+	// OrderLexical processes directories in ascending order of CrawlOptions.KeyFunc,
+	// regardless of discovery order - e.g. to make sync output deterministic and
+	// diff-friendly against another tool's listing.
+	OrderLexical
+	// OrderLargestFirst processes the directory with the largest CrawlOptions.WeightFunc
+	// value next, regardless of discovery order.
+	OrderLargestFirst
+)
+
+// dirQueue is the frontier of unstarted directories. processOneDirectory's cond-based
+// wait/broadcast logic is the same no matter which implementation backs it; only the order
+// Pop hands directories back out differs.
+type dirQueue interface {
+	Push(Directory)
+	Pop() (Directory, bool)
+	Len() int
+}
+
+// newDirQueue builds the dirQueue selected by options.Order.
+func newDirQueue(options CrawlOptions) (dirQueue, error) {
+	switch options.Order {
+	case OrderFIFO:
+		return &fifoQueue{}, nil
+	case OrderLIFO:
+		return &lifoQueue{}, nil
+	case OrderLexical:
+		if options.KeyFunc == nil {
+			return nil, fmt.Errorf("CrawlOptions.KeyFunc is required when Order is OrderLexical")
+		}
+		return &lexicalQueue{keyFunc: options.KeyFunc}, nil
+	case OrderLargestFirst:
+		if options.WeightFunc == nil {
+			return nil, fmt.Errorf("CrawlOptions.WeightFunc is required when Order is OrderLargestFirst")
+		}
+		return &largestFirstQueue{weightFunc: options.WeightFunc}, nil
+	default:
+		return nil, fmt.Errorf("unknown CrawlOrder %d", options.Order)
+	}
+}
+
+// fifoQueue is a plain slice used as a queue via a head index, reclaiming the discarded
+// prefix once it's grown large rather than holding onto every popped slot forever.
+type fifoQueue struct {
+	items []Directory
+	head  int
+}
+
+func (q *fifoQueue) Push(d Directory) {
+	q.items = append(q.items, d)
+}
+
+func (q *fifoQueue) Pop() (Directory, bool) {
+	if q.head >= len(q.items) {
+		return nil, false
+	}
+	d := q.items[q.head]
+	q.items[q.head] = nil // let the GC reclaim it
+	q.head++
+	if q.head == len(q.items) {
+		q.items = q.items[:0]
+		q.head = 0
+	} else if q.head > 1024 && q.head*2 > len(q.items) {
+		q.items = append(q.items[:0], q.items[q.head:]...)
+		q.head = 0
+	}
+	return d, true
+}
+
+func (q *fifoQueue) Len() int {
+	return len(q.items) - q.head
+}
+
+// lifoQueue gives a depth-first traversal: the most recently pushed directory is the next
+// one popped.
+type lifoQueue struct {
+	items []Directory
+}
+
+func (q *lifoQueue) Push(d Directory) {
+	q.items = append(q.items, d)
+}
+
+func (q *lifoQueue) Pop() (Directory, bool) {
+	n := len(q.items)
+	if n == 0 {
+		return nil, false
+	}
+	d := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	return d, true
+}
+
+func (q *lifoQueue) Len() int {
+	return len(q.items)
+}
+
+// lexicalQueue is a min-heap keyed by keyFunc, so Pop always returns the smallest remaining
+// key regardless of discovery order.
+type lexicalQueue struct {
+	keyFunc func(Directory) string
+	h       keyedHeap
+}
+
+func (q *lexicalQueue) Push(d Directory) {
+	heap.Push(&q.h, keyedItem{dir: d, key: q.keyFunc(d)})
+}
+
+func (q *lexicalQueue) Pop() (Directory, bool) {
+	if q.h.Len() == 0 {
+		return nil, false
+	}
+	return heap.Pop(&q.h).(keyedItem).dir, true
+}
+
+func (q *lexicalQueue) Len() int {
+	return q.h.Len()
+}
+
+type keyedItem struct {
+	dir Directory
+	key string
+}
+
+type keyedHeap []keyedItem
+
+func (h keyedHeap) Len() int           { return len(h) }
+func (h keyedHeap) Less(i, j int) bool { return h[i].key < h[j].key }
+func (h keyedHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *keyedHeap) Push(x interface{}) {
+	*h = append(*h, x.(keyedItem))
+}
+
+func (h *keyedHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// largestFirstQueue is a max-heap keyed by weightFunc, so Pop always returns the heaviest
+// remaining directory (e.g. the one with the most estimated entries) regardless of
+// discovery order.
+type largestFirstQueue struct {
+	weightFunc func(Directory) float64
+	h          weightedHeap
+}
+
+func (q *largestFirstQueue) Push(d Directory) {
+	heap.Push(&q.h, weightedItem{dir: d, weight: q.weightFunc(d)})
+}
+
+func (q *largestFirstQueue) Pop() (Directory, bool) {
+	if q.h.Len() == 0 {
+		return nil, false
+	}
+	return heap.Pop(&q.h).(weightedItem).dir, true
+}
+
+func (q *largestFirstQueue) Len() int {
+	return q.h.Len()
+}
+
+type weightedItem struct {
+	dir    Directory
+	weight float64
+}
+
+type weightedHeap []weightedItem
+
+func (h weightedHeap) Len() int           { return len(h) }
+func (h weightedHeap) Less(i, j int) bool { return h[i].weight > h[j].weight } // max-heap
+func (h weightedHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *weightedHeap) Push(x interface{}) {
+	*h = append(*h, x.(weightedItem))
+}
+
+func (h *weightedHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
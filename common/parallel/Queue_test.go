@@ -0,0 +1,130 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import "testing"
+
+func TestFifoQueueIsFirstInFirstOut(t *testing.T) {
+	q := &fifoQueue{}
+	for _, d := range []string{"a", "b", "c"} {
+		q.Push(d)
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		if d, ok := q.Pop(); !ok || d != want {
+			t.Fatalf("Pop() = (%v, %v), want (%q, true)", d, ok, want)
+		}
+	}
+	if _, ok := q.Pop(); ok {
+		t.Fatalf("Pop() on an empty fifoQueue returned ok=true")
+	}
+}
+
+func TestFifoQueueReclaimsHeadAfterDraining(t *testing.T) {
+	// Covers the reset taken whenever a pop drains the queue (head == len(items)): each push
+	// is popped straight away, so the queue is drained 2000 times. Then confirm later
+	// pushes/pops still behave like a plain queue.
+	q := &fifoQueue{}
+	for i := 0; i < 2000; i++ {
+		q.Push(i)
+		if _, ok := q.Pop(); !ok {
+			t.Fatalf("Pop() failed to drain entry %d", i)
+		}
+	}
+	if got := q.Len(); got != 0 {
+		t.Fatalf("Len() = %d, want 0 after draining every push", got)
+	}
+	q.Push("tail")
+	if d, ok := q.Pop(); !ok || d != "tail" {
+		t.Fatalf("Pop() after reclaim = (%v, %v), want (\"tail\", true)", d, ok)
+	}
+}
+
+func TestLifoQueueIsLastInFirstOut(t *testing.T) {
+	q := &lifoQueue{}
+	for _, d := range []string{"a", "b", "c"} {
+		q.Push(d)
+	}
+	for _, want := range []string{"c", "b", "a"} {
+		if d, ok := q.Pop(); !ok || d != want {
+			t.Fatalf("Pop() = (%v, %v), want (%q, true)", d, ok, want)
+		}
+	}
+	if _, ok := q.Pop(); ok {
+		t.Fatalf("Pop() on an empty lifoQueue returned ok=true")
+	}
+}
+
+func TestLexicalQueuePopsAscendingKeyRegardlessOfPushOrder(t *testing.T) {
+	q := &lexicalQueue{keyFunc: func(d Directory) string { return d.(string) }}
+	for _, d := range []string{"banana", "apple", "cherry", "apricot"} {
+		q.Push(d)
+	}
+	var got []string
+	for q.Len() > 0 {
+		d, ok := q.Pop()
+		if !ok {
+			t.Fatalf("Pop() returned ok=false with Len()=%d remaining", q.Len())
+		}
+		got = append(got, d.(string))
+	}
+	want := []string{"apple", "apricot", "banana", "cherry"}
+	for i, w := range want {
+		if got[i] != w {
+			t.Fatalf("pop order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLargestFirstQueuePopsDescendingWeightRegardlessOfPushOrder(t *testing.T) {
+	weights := map[string]float64{"small": 1, "medium": 5, "large": 100, "tiny": 0.1}
+	q := &largestFirstQueue{weightFunc: func(d Directory) float64 { return weights[d.(string)] }}
+	for _, d := range []string{"small", "large", "tiny", "medium"} {
+		q.Push(d)
+	}
+	var got []string
+	for q.Len() > 0 {
+		d, ok := q.Pop()
+		if !ok {
+			t.Fatalf("Pop() returned ok=false with Len()=%d remaining", q.Len())
+		}
+		got = append(got, d.(string))
+	}
+	want := []string{"large", "medium", "small", "tiny"}
+	for i, w := range want {
+		if got[i] != w {
+			t.Fatalf("pop order = %v, want %v (largest weight first)", got, want)
+		}
+	}
+}
+
+func TestNewDirQueueRequiresKeyFuncAndWeightFuncForTheirOrders(t *testing.T) {
+	if _, err := newDirQueue(CrawlOptions{Order: OrderLexical}); err == nil {
+		t.Error("newDirQueue with OrderLexical and no KeyFunc returned a nil error")
+	}
+	if _, err := newDirQueue(CrawlOptions{Order: OrderLargestFirst}); err == nil {
+		t.Error("newDirQueue with OrderLargestFirst and no WeightFunc returned a nil error")
+	}
+	if q, err := newDirQueue(CrawlOptions{}); err != nil {
+		t.Errorf("newDirQueue with the zero-value Order (FIFO): %v", err)
+	} else if _, ok := q.(*fifoQueue); !ok {
+		t.Errorf("newDirQueue with the zero-value Order = %T, want *fifoQueue", q)
+	}
+}
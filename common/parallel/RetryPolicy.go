@@ -0,0 +1,84 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy governs how processOneDirectory retries a single directory after a transient
+// error from workerBody (e.g. a 503 listing a blob virtual directory), instead of losing
+// every entry under it to one bad response.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times workerBody is called for one directory
+	// before giving up, including the first attempt. Values below 1 are treated as 1.
+	MaxAttempts int
+
+	// InitialBackoff is the backoff ceiling used for the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps how large the backoff ceiling can grow across retries.
+	MaxBackoff time.Duration
+
+	// IsRetryable reports whether err is transient and worth retrying. Errors it rejects
+	// are returned immediately, same as if RetryPolicy weren't set at all.
+	IsRetryable func(error) bool
+}
+
+// RetryWarning wraps a failed attempt that RetryPolicy is about to retry. It's carried as
+// the err of an intermediate CrawlResult - emitted in addition to, not instead of, any
+// CrawlResult for the eventual outcome - so progress reporters can surface retry activity
+// without mistaking it for a final failure.
+type RetryWarning struct {
+	// Attempt is the 1-based attempt number that failed.
+	Attempt int
+	Err     error
+}
+
+func (w RetryWarning) Error() string {
+	return fmt.Sprintf("attempt %d failed, retrying: %v", w.Attempt, w.Err)
+}
+
+func (w RetryWarning) Unwrap() error {
+	return w.Err
+}
+
+// fullJitterBackoff picks a random backoff in [0, min(maxBackoff, initialBackoff*2^(attempt-1))],
+// per the "full jitter" strategy: spreading retries out so that many workers hitting the
+// same transient error don't all retry in lockstep.
+func fullJitterBackoff(initialBackoff, maxBackoff time.Duration, attempt int) time.Duration {
+	if initialBackoff <= 0 {
+		initialBackoff = time.Second
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = initialBackoff
+	}
+
+	ceiling := maxBackoff
+	if shift := attempt - 1; shift < 62 { // avoid overflowing time.Duration's int64 on pathologically large attempt counts
+		if scaled := initialBackoff * time.Duration(int64(1)<<uint(shift)); scaled > 0 && scaled < maxBackoff {
+			ceiling = scaled
+		}
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
@@ -0,0 +1,179 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestProcessOneDirectoryDiscardsPartialResultsOnRetry checks that directories enqueued
+// during a failed attempt don't leak into the frontier once a later retry succeeds - only
+// the successful attempt's own results should survive.
+func TestProcessOneDirectoryDiscardsPartialResultsOnRetry(t *testing.T) {
+	attempts := 0
+	worker := func(d Directory, enqueueDir func(Directory), enqueueOutput func(DirectoryEntry)) error {
+		attempts++
+		if attempts == 1 {
+			enqueueDir("should-not-appear")
+			return errors.New("transient failure")
+		}
+		enqueueDir("child")
+		return nil
+	}
+
+	frontier := &fifoQueue{}
+	c := &crawler{
+		frontier:        frontier,
+		maxInMemoryDirs: defaultMaxInMemoryDirs,
+		workerBody:      worker,
+		output:          make(chan ErrorableItem, 10),
+		cond:            sync.NewCond(&sync.Mutex{}),
+		options: CrawlOptions{
+			RetryPolicy: &RetryPolicy{
+				MaxAttempts:    2,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     time.Millisecond,
+				IsRetryable:    func(error) bool { return true },
+			},
+		},
+	}
+	c.frontier.Push("root")
+
+	more, err := c.processOneDirectory(context.Background())
+	if err != nil {
+		t.Fatalf("processOneDirectory: %v", err)
+	}
+	if !more {
+		t.Fatalf("processOneDirectory reported no more work, want true")
+	}
+
+	if got := frontier.Len(); got != 1 {
+		t.Fatalf("frontier.Len() = %d, want 1 (only the successful retry's child, not the discarded first attempt's)", got)
+	}
+	if d, ok := frontier.Pop(); !ok || d != "child" {
+		t.Fatalf("frontier popped (%v, %v), want (\"child\", true)", d, ok)
+	}
+
+	select {
+	case item := <-c.output:
+		var warn RetryWarning
+		_, itemErr := item.Item()
+		if !errors.As(itemErr, &warn) {
+			t.Fatalf("output item = %v, want a RetryWarning for the failed first attempt", itemErr)
+		}
+	default:
+		t.Fatal("expected a RetryWarning on output for the failed first attempt, got nothing")
+	}
+
+	select {
+	case item := <-c.output:
+		t.Fatalf("unexpected extra output item: %v", item)
+	default:
+	}
+}
+
+// TestProcessOneDirectoryRetryDoesNotDuplicateOutputOrFalselyDetectCycles covers a directory
+// whose listing (like a real Azure blob listing) yields both files and subdirectories: a
+// transient failure after a partial listing, followed by a successful retry that re-lists the
+// same children, must neither duplicate the files nor have IdentityFunc mistake the retry's
+// re-enqueued subdirectory for a cycle back to itself.
+func TestProcessOneDirectoryRetryDoesNotDuplicateOutputOrFalselyDetectCycles(t *testing.T) {
+	attempts := 0
+	worker := func(d Directory, enqueueDir func(Directory), enqueueOutput func(DirectoryEntry)) error {
+		attempts++
+		enqueueOutput("file.txt")
+		enqueueDir("child")
+		if attempts == 1 {
+			return errors.New("transient failure")
+		}
+		return nil
+	}
+
+	frontier := &fifoQueue{}
+	c := &crawler{
+		frontier:        frontier,
+		maxInMemoryDirs: defaultMaxInMemoryDirs,
+		workerBody:      worker,
+		output:          make(chan ErrorableItem, 10),
+		cond:            sync.NewCond(&sync.Mutex{}),
+		cycles:          newCycleTracker(func(d Directory) (string, bool) { return d.(string), true }),
+		options: CrawlOptions{
+			RetryPolicy: &RetryPolicy{
+				MaxAttempts:    2,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     time.Millisecond,
+				IsRetryable:    func(error) bool { return true },
+			},
+		},
+	}
+	c.cycles.registerRoot("root")
+	c.frontier.Push("root")
+
+	more, err := c.processOneDirectory(context.Background())
+	if err != nil {
+		t.Fatalf("processOneDirectory: %v", err)
+	}
+	if !more {
+		t.Fatalf("processOneDirectory reported no more work, want true")
+	}
+	if attempts != 2 {
+		t.Fatalf("worker ran %d times, want 2 (one failure, one successful retry)", attempts)
+	}
+
+	if got := frontier.Len(); got != 1 {
+		t.Fatalf("frontier.Len() = %d, want 1 - the retry's \"child\" should not have been rejected as a false cycle", got)
+	}
+	if d, ok := frontier.Pop(); !ok || d != "child" {
+		t.Fatalf("frontier popped (%v, %v), want (\"child\", true)", d, ok)
+	}
+
+	var warnings, files int
+	for {
+		select {
+		case item := <-c.output:
+			val, itemErr := item.Item()
+			if itemErr != nil {
+				var warn RetryWarning
+				if !errors.As(itemErr, &warn) {
+					t.Fatalf("unexpected output error: %v", itemErr)
+				}
+				warnings++
+				continue
+			}
+			if val != "file.txt" {
+				t.Fatalf("unexpected output item: %v", val)
+			}
+			files++
+		default:
+			if warnings != 1 {
+				t.Errorf("got %d RetryWarnings, want 1", warnings)
+			}
+			if files != 1 {
+				t.Errorf("got %d \"file.txt\" outputs, want 1 (the failed attempt's must not be duplicated on the successful retry)", files)
+			}
+			return
+		}
+	}
+}
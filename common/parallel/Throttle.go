@@ -0,0 +1,137 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"context"
+	"sync"
+)
+
+// Throttle is a semaphore whose capacity can be changed at runtime via SetLimit. Unlike a
+// fixed-size worker pool, a single Throttle can be shared across multiple concurrent
+// CrawlWithOptions/ResumeCrawl calls (e.g. a copy job crawling its source and destination at the
+// same time) so they stay within one combined concurrency budget, and its limit can be
+// adapted on the fly - see AIMDPolicy.
+type Throttle struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int
+	inUse int
+}
+
+// NewThrottle creates a Throttle that allows up to initialLimit concurrent holders.
+func NewThrottle(initialLimit int) *Throttle {
+	if initialLimit < 1 {
+		initialLimit = 1
+	}
+	t := &Throttle{limit: initialLimit}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// Acquire blocks until a slot is available under the current limit, or ctx is done.
+func (t *Throttle) Acquire(ctx context.Context) error {
+	stopWaiting := make(chan struct{})
+	defer close(stopWaiting)
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.mu.Lock()
+			t.cond.Broadcast() // wake Acquire below so it notices ctx is done
+			t.mu.Unlock()
+		case <-stopWaiting:
+		}
+	}()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.inUse >= t.limit && ctx.Err() == nil {
+		t.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	t.inUse++
+	return nil
+}
+
+// Release frees up a slot acquired via Acquire.
+func (t *Throttle) Release() {
+	t.mu.Lock()
+	t.inUse--
+	t.cond.Broadcast()
+	t.mu.Unlock()
+}
+
+// SetLimit changes how many holders Acquire admits at once, waking anyone waiting in case
+// the new limit lets them through. A limit below 1 is treated as 1.
+func (t *Throttle) SetLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+	t.mu.Lock()
+	t.limit = n
+	t.cond.Broadcast()
+	t.mu.Unlock()
+}
+
+// Limit returns the current limit.
+func (t *Throttle) Limit() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+// AIMDPolicy adapts a Throttle's limit using additive-increase/multiplicative-decrease: on
+// any worker error IsThrottled recognizes as throttling/timeout, the limit is immediately
+// halved (down to a floor of 1); on a run of CleanWindow consecutive directories processed
+// without such an error, the limit grows by one, up to MaxParallelism.
+type AIMDPolicy struct {
+	// IsThrottled reports whether err indicates the remote side is throttling or timing
+	// out, as opposed to some other (e.g. permission) failure that more workers wouldn't fix.
+	IsThrottled func(error) bool
+
+	// MaxParallelism is the ceiling the additive increase won't grow the throttle past.
+	MaxParallelism int
+
+	// CleanWindow is how many consecutive non-throttled directories must be processed
+	// before the limit is increased by one. Zero disables growth, so the limit can only
+	// ever shrink from whatever it started at.
+	CleanWindow int
+}
+
+// adapt applies policy to the outcome of one directory, given the throttle it governs and
+// the consecutive-clean-completions streak so far; it returns the updated streak.
+func (policy *AIMDPolicy) adapt(t *Throttle, bodyErr error, cleanStreak int) int {
+	if bodyErr != nil && policy.IsThrottled(bodyErr) {
+		t.SetLimit(t.Limit() / 2)
+		return 0
+	}
+
+	cleanStreak++
+	if policy.CleanWindow > 0 && cleanStreak >= policy.CleanWindow {
+		if newLimit := t.Limit() + 1; newLimit <= policy.MaxParallelism {
+			t.SetLimit(newLimit)
+		}
+		return 0
+	}
+	return cleanStreak
+}
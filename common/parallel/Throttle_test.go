@@ -0,0 +1,139 @@
+// Copyright © Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parallel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestThrottleAcquireBlocksUntilReleaseOrHigherLimit(t *testing.T) {
+	th := NewThrottle(1)
+	if err := th.Acquire(context.Background()); err != nil {
+		t.Fatalf("first Acquire: %v", err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		_ = th.Acquire(context.Background())
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second Acquire returned before any slot was freed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	th.Release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second Acquire never unblocked after Release")
+	}
+}
+
+func TestThrottleAcquireReturnsOnContextCancellation(t *testing.T) {
+	th := NewThrottle(1)
+	if err := th.Acquire(context.Background()); err != nil {
+		t.Fatalf("first Acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- th.Acquire(ctx) }()
+
+	time.Sleep(10 * time.Millisecond) // give the goroutine a chance to start waiting
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Acquire returned %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Acquire never returned after its context was cancelled")
+	}
+}
+
+func TestThrottleSetLimitFloorsAtOne(t *testing.T) {
+	th := NewThrottle(5)
+	th.SetLimit(0)
+	if got := th.Limit(); got != 1 {
+		t.Errorf("Limit() = %d after SetLimit(0), want 1", got)
+	}
+	th.SetLimit(-3)
+	if got := th.Limit(); got != 1 {
+		t.Errorf("Limit() = %d after SetLimit(-3), want 1", got)
+	}
+}
+
+func TestAIMDPolicyHalvesLimitOnThrottledError(t *testing.T) {
+	th := NewThrottle(8)
+	policy := &AIMDPolicy{IsThrottled: func(error) bool { return true }, MaxParallelism: 100, CleanWindow: 3}
+
+	streak := policy.adapt(th, errors.New("429 throttled"), 2)
+	if got := th.Limit(); got != 4 {
+		t.Errorf("Limit() = %d after a throttled error halved 8, want 4", got)
+	}
+	if streak != 0 {
+		t.Errorf("clean streak = %d after a throttled error, want reset to 0", streak)
+	}
+}
+
+func TestAIMDPolicyGrowsLimitAfterCleanWindowThenStopsAtMax(t *testing.T) {
+	th := NewThrottle(2)
+	policy := &AIMDPolicy{IsThrottled: func(error) bool { return false }, MaxParallelism: 3, CleanWindow: 2}
+
+	streak := 0
+	streak = policy.adapt(th, nil, streak) // 1 of 2 clean completions: not enough to grow yet
+	if got := th.Limit(); got != 2 {
+		t.Fatalf("Limit() = %d after 1 clean completion (CleanWindow=2), want unchanged 2", got)
+	}
+	streak = policy.adapt(th, nil, streak) // 2 of 2: grows by one
+	if got := th.Limit(); got != 3 {
+		t.Fatalf("Limit() = %d after CleanWindow clean completions, want 3", got)
+	}
+	if streak != 0 {
+		t.Fatalf("clean streak = %d right after growing, want reset to 0", streak)
+	}
+
+	streak = policy.adapt(th, nil, streak)
+	streak = policy.adapt(th, nil, streak) // would grow to 4, but MaxParallelism caps it at 3
+	if got := th.Limit(); got != 3 {
+		t.Fatalf("Limit() = %d, want capped at MaxParallelism=3", got)
+	}
+}
+
+func TestAIMDPolicyDisablesGrowthWhenCleanWindowIsZero(t *testing.T) {
+	th := NewThrottle(2)
+	policy := &AIMDPolicy{IsThrottled: func(error) bool { return false }, MaxParallelism: 10, CleanWindow: 0}
+
+	streak := 0
+	for i := 0; i < 50; i++ {
+		streak = policy.adapt(th, nil, streak)
+	}
+	if got := th.Limit(); got != 2 {
+		t.Errorf("Limit() = %d after many clean completions with CleanWindow=0, want unchanged 2", got)
+	}
+}
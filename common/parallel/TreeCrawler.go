@@ -22,18 +22,97 @@ package parallel
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 )
 
+// defaultMaxInMemoryDirs is the capacity of the in-memory frontier when CrawlOptions isn't
+// used (or leaves MaxInMemoryDirs at zero). It matches the channel size this package has
+// always used.
+const defaultMaxInMemoryDirs = 1000
+
+// CrawlOptions customizes the behaviour of CrawlWithOptions. The zero value reproduces
+// today's behaviour: a 1000-deep in-memory frontier with no spilling to disk.
+type CrawlOptions struct {
+	// MaxInMemoryDirs caps how many unstarted directories are kept in memory at once. Once
+	// the in-memory frontier is full, newly discovered directories are spilled to segment
+	// files under SpillDir instead of blocking the worker that found them. Zero disables
+	// spilling and falls back to a fixed 1000-deep in-memory frontier, as before.
+	MaxInMemoryDirs int
+
+	// SpillDir is the directory under which overflow segment files are created. If empty,
+	// os.TempDir() is used. Ignored unless MaxInMemoryDirs > 0.
+	SpillDir string
+
+	// Marshaler serializes/deserializes Directory values for the overflow segment files and,
+	// when using ResumeCrawl, the checkpoint file. Required whenever MaxInMemoryDirs > 0 or
+	// a checkpoint is in use.
+	Marshaler *DirectoryMarshaler
+
+	// CheckpointInterval controls how often ResumeCrawl flushes its checkpoint to disk.
+	// Zero uses a 30s default. Ignored by Crawl/CrawlWithOptions.
+	CheckpointInterval time.Duration
+
+	// Order controls what order unstarted directories are handed to workers in. The zero
+	// value, OrderFIFO, preserves today's behaviour.
+	Order CrawlOrder
+
+	// KeyFunc extracts the sort key used to order directories when Order is OrderLexical.
+	// Required (and only used) in that case.
+	KeyFunc func(Directory) string
+
+	// WeightFunc extracts the weight used to order directories when Order is
+	// OrderLargestFirst; the largest weight is processed first. Required (and only used)
+	// in that case.
+	WeightFunc func(Directory) float64
+
+	// Throttle, if set, is acquired around each call into worker and released afterwards,
+	// capping how many directories can be processed concurrently independently of
+	// parallelism (the number of worker goroutines pulling from the frontier). Share one
+	// Throttle across concurrent crawls to keep them within a combined budget.
+	Throttle *Throttle
+
+	// AIMD adapts Throttle's limit in response to worker errors; see AIMDPolicy. Ignored
+	// unless Throttle is also set.
+	AIMD *AIMDPolicy
+
+	// IdentityFunc, if set, lets the crawler recognize when it's been asked to enqueue a
+	// directory it has already visited - e.g. via a symlink or hard-link loop - so it can
+	// drop the repeat and emit ErrCycleDetected instead of crawling forever. ok is false if
+	// d has no usable identity (in which case it's always treated as new).
+	IdentityFunc func(d Directory) (key string, ok bool)
+
+	// RetryPolicy, if set, retries a directory (with full-jitter exponential backoff)
+	// instead of giving up on its whole subtree after one transient error. See RetryPolicy.
+	RetryPolicy *RetryPolicy
+}
+
 type crawler struct {
 	output      chan ErrorableItem
 	workerBody  EnumerateOneDirFunc
 	parallelism int
+	options     CrawlOptions
 	cond        *sync.Cond
 	// the following is protected by cond (and must only be accessed when cond.L is held)
-	unstartedDirs      chan Directory // protected by cond.L because we use len() on this, and need to hold lock while making len-based decisions
+	frontier           dirQueue // the unstarted directories, in whatever order options.Order selects
+	maxInMemoryDirs    int
 	dirInProgressCount int64
+	completedCount     int64       // monotonic count of directories fully processed; only tracked so checkpoint has a watermark
+	inProgressDirs     []Directory // only populated when checkpoint != nil; see enqueueFoundDirLocked's counterpart in processOneDirectory
+	// overflow is non-nil only when options.MaxInMemoryDirs > 0. It holds directories that
+	// don't currently fit in c.frontier, so that processOneDirectory never has to block on a
+	// full frontier while holding cond.L.
+	overflow *diskOverflow
+	// checkpoint is non-nil only for crawls started via ResumeCrawl.
+	checkpoint *CrawlCheckpoint
+	// cycles is non-nil only when options.IdentityFunc is set.
+	cycles *cycleTracker
+
+	// aimdMu protects cleanStreak, the running count of consecutive non-throttled
+	// directories used by options.AIMD to decide when to grow options.Throttle's limit.
+	aimdMu      sync.Mutex
+	cleanStreak int
 }
 
 type Directory interface{}
@@ -51,20 +130,168 @@ func (r CrawlResult) Item() (interface{}, error) {
 // must be safe to be simultaneously called by multiple go-routines, each with a different dir
 type EnumerateOneDirFunc func(dir Directory, enqueueDir func(Directory), enqueueOutput func(DirectoryEntry)) error
 
+// Crawl starts a crawl with today's fixed 1000-deep in-memory frontier and no spilling to
+// disk. It's equivalent to CrawlWithOptions(ctx, root, worker, parallelism, CrawlOptions{}).
 func Crawl(ctx context.Context, root Directory, worker EnumerateOneDirFunc, parallelism int) <-chan ErrorableItem {
+	return CrawlWithOptions(ctx, root, worker, parallelism, CrawlOptions{})
+}
+
+// CrawlWithOptions is Crawl with control over the size of the in-memory frontier and, once
+// that fills up, where overflow directories get spilled to disk. See CrawlOptions.
+func CrawlWithOptions(ctx context.Context, root Directory, worker EnumerateOneDirFunc, parallelism int, options CrawlOptions) <-chan ErrorableItem {
+	maxInMemoryDirs := options.MaxInMemoryDirs
+	if maxInMemoryDirs <= 0 {
+		maxInMemoryDirs = defaultMaxInMemoryDirs
+	}
+	frontier, err := newDirQueue(options)
+	if err != nil {
+		return singleErrorOutput(fmt.Errorf("starting crawl: %w", err))
+	}
 	c := &crawler{
-		unstartedDirs: make(chan Directory, 1000),
-		output:        make(chan ErrorableItem, 1000),
-		workerBody:    worker,
-		parallelism:   parallelism,
-		cond:          sync.NewCond(&sync.Mutex{}),
+		frontier:        frontier,
+		maxInMemoryDirs: maxInMemoryDirs,
+		output:          make(chan ErrorableItem, 1000),
+		workerBody:      worker,
+		parallelism:     parallelism,
+		options:         options,
+		cond:            sync.NewCond(&sync.Mutex{}),
+	}
+	if options.MaxInMemoryDirs > 0 {
+		if options.Marshaler == nil {
+			return singleErrorOutput(fmt.Errorf("starting crawl: CrawlOptions.Marshaler is required when MaxInMemoryDirs > 0"))
+		}
+		overflow, err := newDiskOverflow(options.SpillDir, options.Marshaler)
+		if err != nil {
+			return singleErrorOutput(fmt.Errorf("starting crawl: %w", err))
+		}
+		c.overflow = overflow
+	}
+	if options.IdentityFunc != nil {
+		c.cycles = newCycleTracker(options.IdentityFunc)
 	}
 	go c.start(ctx, root)
 	return c.output
 }
 
+// singleErrorOutput returns an already-closed output channel yielding just err, for the
+// handful of setup failures that can happen before a crawler exists to report through.
+func singleErrorOutput(err error) <-chan ErrorableItem {
+	errOutput := make(chan ErrorableItem, 1)
+	errOutput <- CrawlResult{err: err}
+	close(errOutput)
+	return errOutput
+}
+
+// ResumeCrawl resumes a crawl from the checkpoint at checkpointPath, starting fresh from
+// root if no checkpoint exists there yet. While running, it periodically persists its
+// frontier, in-progress directories, and a completed-directory watermark back to
+// checkpointPath (guarded by a cross-process advisory lock) so a later ResumeCrawl against
+// the same path can pick up where this one left off, even across a process restart.
+//
+// If an existing checkpoint was found, the very first value read from the returned channel
+// is a CrawlResult wrapping a ResumeMarker, so callers can report how much of the crawl had
+// already completed. options.Marshaler must be set.
+func ResumeCrawl(ctx context.Context, checkpointPath string, root Directory, worker EnumerateOneDirFunc, parallelism int, options CrawlOptions) (<-chan ErrorableItem, error) {
+	if options.Marshaler == nil {
+		return nil, fmt.Errorf("ResumeCrawl requires options.Marshaler to (de)serialize the checkpoint")
+	}
+	cp, frontier, inProgress, err := newCheckpoint(checkpointPath, options.Marshaler, options.CheckpointInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	maxInMemoryDirs := options.MaxInMemoryDirs
+	if maxInMemoryDirs <= 0 {
+		maxInMemoryDirs = defaultMaxInMemoryDirs
+	}
+	queue, err := newDirQueue(options)
+	if err != nil {
+		cp.close()
+		return nil, fmt.Errorf("starting crawl: %w", err)
+	}
+	c := &crawler{
+		frontier:        queue,
+		maxInMemoryDirs: maxInMemoryDirs,
+		output:          make(chan ErrorableItem, 1000),
+		workerBody:      worker,
+		parallelism:     parallelism,
+		options:         options,
+		checkpoint:      cp,
+		cond:            sync.NewCond(&sync.Mutex{}),
+	}
+	if options.MaxInMemoryDirs > 0 {
+		overflow, overflowErr := newDiskOverflow(options.SpillDir, options.Marshaler)
+		if overflowErr != nil {
+			cp.close()
+			return nil, fmt.Errorf("starting crawl: %w", overflowErr)
+		}
+		c.overflow = overflow
+	}
+	if options.IdentityFunc != nil {
+		c.cycles = newCycleTracker(options.IdentityFunc)
+	}
+
+	if !cp.resumed {
+		frontier = []Directory{root}
+		if c.cycles != nil {
+			c.cycles.registerRoot(root)
+		}
+	}
+	go c.startResumed(ctx, frontier, inProgress)
+	return c.output, nil
+}
+
 func (c *crawler) start(ctx context.Context, root Directory) {
 	done := make(chan struct{})
+	if c.overflow != nil {
+		defer c.overflow.close()
+	}
+	c.runBackgroundHelpers(ctx, done)
+	if c.cycles != nil {
+		c.cycles.registerRoot(root)
+	}
+
+	c.cond.L.Lock()
+	c.frontier.Push(root)
+	c.cond.L.Unlock()
+	c.runWorkersToCompletion(ctx)
+	close(c.output)
+	close(done)
+}
+
+// startResumed is start's counterpart for ResumeCrawl: the frontier and in-progress
+// directories come from a checkpoint (or, for a brand new checkpoint, frontier is just
+// [root]) rather than a single root.
+func (c *crawler) startResumed(ctx context.Context, frontier, inProgress []Directory) {
+	done := make(chan struct{})
+	defer c.checkpoint.close()
+	if c.overflow != nil {
+		defer c.overflow.close()
+	}
+	c.runBackgroundHelpers(ctx, done)
+	go c.runCheckpointer(ctx, done)
+
+	if c.checkpoint.resumed {
+		c.completedCount = c.checkpoint.completedAtLoad
+		c.output <- CrawlResult{item: ResumeMarker{CompletedOffset: c.checkpoint.completedAtLoad}}
+	}
+
+	// directories that were "in progress" when the checkpoint was taken might or might not
+	// have finished before the crash; safest is to re-run them as if they were never started
+	c.cond.L.Lock()
+	for _, d := range append(frontier, inProgress...) {
+		c.frontier.Push(d)
+	}
+	c.cond.L.Unlock()
+
+	c.runWorkersToCompletion(ctx)
+	close(c.output)
+	close(done)
+}
+
+// runBackgroundHelpers starts the goroutines common to every crawl: the stall-breaking
+// heartbeat, and (when configured) the disk-overflow feeder.
+func (c *crawler) runBackgroundHelpers(ctx context.Context, done chan struct{}) {
 	heartbeat := func() {
 		for {
 			select {
@@ -76,11 +303,45 @@ func (c *crawler) start(ctx context.Context, root Directory) {
 		}
 	}
 	go heartbeat()
+	if c.overflow != nil {
+		go c.runOverflowFeeder(ctx, done)
+	}
+}
 
-	c.unstartedDirs <- root
-	c.runWorkersToCompletion(ctx)
-	close(c.output)
-	close(done)
+// runOverflowFeeder drains spilled directories back into the in-memory frontier whenever
+// it has dropped below a low-water mark (a quarter of maxInMemoryDirs), topping it back up
+// to that mark, keeping processOneDirectory oblivious to whether a given directory came
+// from memory or from disk.
+func (c *crawler) runOverflowFeeder(ctx context.Context, done <-chan struct{}) {
+	lowWater := c.maxInMemoryDirs / 4
+	if lowWater < 1 {
+		lowWater = 1
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.cond.L.Lock()
+			for c.frontier.Len() < lowWater {
+				d, ok, err := c.overflow.pop()
+				if err != nil {
+					c.output <- CrawlResult{err: fmt.Errorf("feeding spilled directory: %w", err)}
+					break
+				}
+				if !ok {
+					break
+				}
+				c.frontier.Push(d)
+			}
+			c.cond.Broadcast()
+			c.cond.L.Unlock()
+		}
+	}
 }
 
 func (c *crawler) runWorkersToCompletion(ctx context.Context) {
@@ -113,23 +374,26 @@ func (c *crawler) processOneDirectory(ctx context.Context) (bool, error) {
 	// Acquire a directory to work on
 	// Note that we need explicit locking because there are two
 	// mutable things involved in our decision making, not one (the two being c.dirs and c.dirInProgressCount)
-	// and because we use len(c.unstartedDirs) which is not accurate unless len and channel manipulation are protected
+	// and because we use c.frontier.Len() which is not accurate unless len and queue manipulation are protected
 	// by the same lock.
 	c.cond.L.Lock()
 	{
 		// wait while there's nothing to do, and another thread might be going to add something
-		for len(c.unstartedDirs) == 0 && c.dirInProgressCount > 0 && ctx.Err() == nil {
+		for c.frontier.Len() == 0 && (c.dirInProgressCount > 0 || c.hasSpilledWorkLocked()) && ctx.Err() == nil {
 			c.cond.Wait() // temporarily relinquish the lock (just on this line only) while we wait for a Signal/Broadcast
 		}
 
 		// if we have something to do now, grab it. Else we must be all finished with nothing more to do (ever)
 		stop = ctx.Err() != nil
 		if !stop {
-			select {
-			case toExamine = <-c.unstartedDirs:
+			if d, ok := c.frontier.Pop(); ok {
+				toExamine = d
 				c.dirInProgressCount++ // record that we are working on something
-				c.cond.Broadcast()     // and let other threads know of that fact
-			default:
+				if c.checkpoint != nil {
+					c.inProgressDirs = append(c.inProgressDirs, toExamine)
+				}
+				c.cond.Broadcast() // and let other threads know of that fact
+			} else {
 				if c.dirInProgressCount > 0 {
 					// something has gone wrong in the design of this algorithm, because we should only get here if all done now
 					panic("assertion failure: should be no more dirs in progress here")
@@ -145,22 +409,187 @@ func (c *crawler) processOneDirectory(ctx context.Context) (bool, error) {
 
 	// find dir's immediate children (outside the lock, because this could be slow)
 	var foundDirectories = make([]Directory, 0, 16)
+	var foundOutputs = make([]DirectoryEntry, 0, 16)
+	var tentativeCycleKeys []string // keys check has registered for this attempt; rolled back if it's retried
+	var cycleParentKey string
+	if c.cycles != nil {
+		cycleParentKey, _ = c.cycles.identify(toExamine)
+	}
 	addDir := func(d Directory) {
+		if c.cycles != nil {
+			key, isNew, cycleErr := c.cycles.check(d, toExamine)
+			if !isNew {
+				c.output <- CrawlResult{err: cycleErr}
+				return
+			}
+			tentativeCycleKeys = append(tentativeCycleKeys, key)
+		}
 		foundDirectories = append(foundDirectories, d)
 	}
 	addOutput := func(e DirectoryEntry) {
+		foundOutputs = append(foundOutputs, e) // buffered, not sent yet: a retry must not duplicate a failed attempt's output
+	}
+
+	var bodyErr error
+	policy := c.options.RetryPolicy
+	if policy == nil {
+		bodyErr = c.callWorkerBodyOnce(ctx, toExamine, addDir, addOutput)
+	} else {
+		maxAttempts := policy.MaxAttempts
+		if maxAttempts < 1 {
+			maxAttempts = 1
+		}
+		for attempt := 1; ; attempt++ {
+			// a failed attempt's partial results - including any cycle-tracker entries it
+			// tentatively registered - must not carry into the retry
+			foundDirectories = foundDirectories[:0]
+			foundOutputs = foundOutputs[:0]
+			for _, key := range tentativeCycleKeys {
+				c.cycles.discard(key, cycleParentKey)
+			}
+			tentativeCycleKeys = tentativeCycleKeys[:0]
+
+			bodyErr = c.callWorkerBodyOnce(ctx, toExamine, addDir, addOutput)
+			if bodyErr == nil || !policy.IsRetryable(bodyErr) || attempt >= maxAttempts || ctx.Err() != nil {
+				break
+			}
+			c.output <- CrawlResult{err: RetryWarning{Attempt: attempt, Err: bodyErr}} // a warning, not (yet) a final failure
+			select {
+			case <-time.After(fullJitterBackoff(policy.InitialBackoff, policy.MaxBackoff, attempt)):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	for _, e := range foundOutputs {
 		c.output <- CrawlResult{item: e}
 	}
-	bodyErr := c.workerBody(toExamine, addDir, addOutput) // this is the worker body supplied by our caller
 
 	// finally, update shared state (inside the lock)
 	c.cond.L.Lock()
 	defer c.cond.L.Unlock()
 	for _, d := range foundDirectories {
-		c.unstartedDirs <- d
+		c.enqueueFoundDirLocked(d)
 	}
 	c.dirInProgressCount-- // we were doing something, and now we have finished it
-	c.cond.Broadcast()     // let other workers know that the state has changed
+	c.completedCount++
+	if c.checkpoint != nil {
+		c.inProgressDirs = removeFirstLocked(c.inProgressDirs, toExamine)
+	}
+	c.cond.Broadcast() // let other workers know that the state has changed
 
 	return true, bodyErr // true because, as far as we know, the work is not finished. And err because it was the err (if any) from THIS dir
 }
+
+// callWorkerBodyOnce invokes workerBody for a single attempt, gating concurrency through
+// options.Throttle (if set) and feeding the outcome to options.AIMD (if set).
+func (c *crawler) callWorkerBodyOnce(ctx context.Context, toExamine Directory, addDir func(Directory), addOutput func(DirectoryEntry)) error {
+	if c.options.Throttle == nil {
+		return c.workerBody(toExamine, addDir, addOutput)
+	}
+	if err := c.options.Throttle.Acquire(ctx); err != nil {
+		return err // ctx was cancelled while waiting for a throttle slot
+	}
+	defer c.options.Throttle.Release()
+
+	bodyErr := c.workerBody(toExamine, addDir, addOutput)
+	if c.options.AIMD != nil {
+		c.aimdMu.Lock()
+		c.cleanStreak = c.options.AIMD.adapt(c.options.Throttle, bodyErr, c.cleanStreak)
+		c.aimdMu.Unlock()
+	}
+	return bodyErr
+}
+
+// hasSpilledWorkLocked reports whether there are directories waiting on disk that the
+// overflow feeder hasn't yet fed back into c.frontier. Called with c.cond.L held, so that a
+// worker about to declare the crawl finished doesn't race the feeder.
+func (c *crawler) hasSpilledWorkLocked() bool {
+	return c.overflow != nil && !c.overflow.empty()
+}
+
+// enqueueFoundDirLocked adds a newly-discovered directory to the frontier. c.cond.L must be
+// held. If the frontier is already at maxInMemoryDirs, it spills to disk (when configured)
+// rather than blocking here indefinitely, which would otherwise deadlock every other worker
+// waiting on cond.L.
+func (c *crawler) enqueueFoundDirLocked(d Directory) {
+	for c.frontier.Len() >= c.maxInMemoryDirs {
+		if c.overflow == nil {
+			// no spill configured: fall back to today's behaviour of blocking until there's room
+			c.cond.Wait()
+			continue
+		}
+		if err := c.overflow.push(d); err != nil {
+			c.output <- CrawlResult{err: fmt.Errorf("spilling directory to disk: %w", err)}
+		}
+		return
+	}
+	c.frontier.Push(d)
+}
+
+// removeFirstLocked returns dirs with the first element equal to d removed, if any. Directory
+// values used with checkpointing need to be comparable (e.g. a string or a small struct of
+// comparable fields) for this to find them.
+func removeFirstLocked(dirs []Directory, d Directory) []Directory {
+	for i, existing := range dirs {
+		if existing == d {
+			return append(dirs[:i], dirs[i+1:]...)
+		}
+	}
+	return dirs
+}
+
+// runCheckpointer periodically flushes the crawl's current frontier, in-progress
+// directories, and completed watermark to c.checkpoint, so the crawl can be resumed after a
+// crash with at most one checkpoint interval of rework.
+func (c *crawler) runCheckpointer(ctx context.Context, done <-chan struct{}) {
+	ticker := time.NewTicker(c.checkpoint.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			c.flushCheckpoint()
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.flushCheckpoint()
+		}
+	}
+}
+
+func (c *crawler) flushCheckpoint() {
+	c.cond.L.Lock()
+	frontier := c.drainAndRestoreFrontierLocked()
+	inProgress := append([]Directory(nil), c.inProgressDirs...)
+	completed := c.completedCount
+	c.cond.L.Unlock()
+
+	if err := c.checkpoint.save(frontier, inProgress, completed); err != nil {
+		c.output <- CrawlResult{err: fmt.Errorf("writing crawl checkpoint: %w", err)}
+	}
+}
+
+// drainAndRestoreFrontierLocked takes a snapshot of c.frontier without losing anything from
+// it: it's drained into a slice and immediately pushed back. c.cond.L must be held so no
+// worker can pop from the frontier while the snapshot is in flight. The directories are
+// pushed back so that the frontier pops them in the same order as before: in pop order for
+// FIFO and heap-backed frontiers, and in reverse pop order for a LIFO frontier.
+func (c *crawler) drainAndRestoreFrontierLocked() []Directory {
+	n := c.frontier.Len()
+	snapshot := make([]Directory, 0, n)
+	for i := 0; i < n; i++ {
+		d, _ := c.frontier.Pop()
+		snapshot = append(snapshot, d)
+	}
+	if _, isLIFO := c.frontier.(*lifoQueue); isLIFO {
+		for i := len(snapshot) - 1; i >= 0; i-- {
+			c.frontier.Push(snapshot[i])
+		}
+	} else {
+		for _, d := range snapshot {
+			c.frontier.Push(d)
+		}
+	}
+	return snapshot
+}